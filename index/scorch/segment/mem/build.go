@@ -209,6 +209,33 @@ func (s *Segment) processDocument(result *index.AnalysisResult) {
 	}
 }
 
+// getOrDefinePosting returns the postings-list id (0-based) for term within
+// fieldID, allocating a new, empty postings list (and growing every
+// parallel Postings/Freqs/Norms/Loc* slice to match) the first time the
+// term is seen. It is the incremental counterpart to the numPostings
+// bookkeeping initializeDict performs up front for NewFromAnalyzedDocs.
+func (s *Segment) getOrDefinePosting(fieldID uint16, term string) uint64 {
+	pid, exists := s.Dicts[fieldID][term]
+	if exists {
+		return pid - 1
+	}
+
+	s.Postings = append(s.Postings, roaring.New())
+	s.PostingsLocs = append(s.PostingsLocs, roaring.New())
+	s.Freqs = append(s.Freqs, nil)
+	s.Norms = append(s.Norms, nil)
+	s.Locfields = append(s.Locfields, nil)
+	s.Locstarts = append(s.Locstarts, nil)
+	s.Locends = append(s.Locends, nil)
+	s.Locpos = append(s.Locpos, nil)
+	s.Locarraypos = append(s.Locarraypos, nil)
+
+	pid = uint64(len(s.Postings))
+	s.Dicts[fieldID][term] = pid
+	s.DictKeys[fieldID] = append(s.DictKeys[fieldID], term)
+	return pid - 1
+}
+
 func (s *Segment) getOrDefineField(name string) int {
 	fieldID, ok := s.FieldsMap[name]
 	if !ok {
@@ -0,0 +1,162 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mem
+
+import (
+	"math"
+	"sort"
+
+	"github.com/blevesearch/bleve/analysis"
+	"github.com/blevesearch/bleve/index"
+)
+
+// SegmentBuilder incrementally assembles a mem.Segment from a stream of
+// analyzed documents. Unlike NewFromAnalyzedDocs, it never requires the
+// full batch of *index.AnalysisResult to be resident at once: Dicts,
+// DictKeys, Postings, Freqs, Norms and the Loc* arrays all grow as
+// documents arrive, so callers can stream results from a channel and
+// bound peak RSS during large offline ingests. DictKeys are left unsorted
+// until Build is called.
+//
+// The resulting Segment is equivalent to one built by NewFromAnalyzedDocs
+// in content - the same documents map to the same terms, postings,
+// norms and locations - but not necessarily in on-disk byte layout.
+// NewFromAnalyzedDocs assigns postings-list ids in a single pre-pass over
+// every document before processing any of them, whereas AddDocument
+// assigns ids lazily, one document at a time, via getOrDefinePosting.
+// Both also iterate a document's analysis.TokenFrequencies map when
+// discovering terms, whose order Go does not guarantee in the first
+// place, so neither path promises a specific id assignment across runs;
+// WriteTo's pid-ordered postings region can therefore differ byte-for-byte
+// between a segment built via NewFromAnalyzedDocs and one built via
+// SegmentBuilder for the same input.
+type SegmentBuilder struct {
+	s *Segment
+}
+
+// NewBuilder creates an empty SegmentBuilder ready to accept documents via
+// AddDocument.
+func NewBuilder() *SegmentBuilder {
+	b := &SegmentBuilder{
+		s: New(),
+	}
+
+	// ensure that _id field gets fieldID 0, matching NewFromAnalyzedDocs
+	b.s.getOrDefineField("_id")
+
+	return b
+}
+
+// AddDocument analyzes and appends a single document's postings, norms,
+// locations and stored fields to the segment under construction. It is
+// the streaming counterpart to processDocument, differing only in how
+// postings-list ids are allocated: on demand via getOrDefinePosting
+// rather than up front via initializeDict.
+func (b *SegmentBuilder) AddDocument(result *index.AnalysisResult) error {
+	s := b.s
+
+	// used to collate information across fields
+	docMap := make(map[uint16]analysis.TokenFrequencies, len(s.FieldsMap))
+	fieldLens := make(map[uint16]int, len(s.FieldsMap))
+
+	docNum := uint64(s.addDocument())
+
+	processField := func(field uint16, name string, l int, tf analysis.TokenFrequencies) {
+		fieldLens[field] += l
+		if existingFreqs, ok := docMap[field]; ok {
+			existingFreqs.MergeAll(name, tf)
+		} else {
+			docMap[field] = tf
+		}
+	}
+
+	storeField := func(docNum uint64, field uint16, typ byte, val []byte, pos []uint64) {
+		s.Stored[docNum][field] = append(s.Stored[docNum][field], val)
+		s.StoredTypes[docNum][field] = append(s.StoredTypes[docNum][field], typ)
+		s.StoredPos[docNum][field] = append(s.StoredPos[docNum][field], pos)
+	}
+
+	// walk each composite field
+	for _, field := range result.Document.CompositeFields {
+		fieldID := uint16(s.getOrDefineField(field.Name()))
+		l, tf := field.Analyze()
+		processField(fieldID, field.Name(), l, tf)
+	}
+
+	// walk each field
+	for i, field := range result.Document.Fields {
+		fieldID := uint16(s.getOrDefineField(field.Name()))
+		l := result.Length[i]
+		tf := result.Analyzed[i]
+		processField(fieldID, field.Name(), l, tf)
+		if field.Options().IsStored() {
+			storeField(docNum, fieldID, encodeFieldType(field), field.Value(), field.ArrayPositions())
+		}
+
+		if field.Options().IncludeDocValues() {
+			s.DocValueFields[fieldID] = true
+		}
+	}
+
+	// now that its been rolled up into docMap, walk that
+	for fieldID, tokenFrequencies := range docMap {
+		for term, tokenFreq := range tokenFrequencies {
+			pid := s.getOrDefinePosting(fieldID, term)
+			bs := s.Postings[pid]
+			bs.AddInt(int(docNum))
+			s.Freqs[pid] = append(s.Freqs[pid], uint64(tokenFreq.Frequency()))
+			s.Norms[pid] = append(s.Norms[pid], float32(1.0/math.Sqrt(float64(fieldLens[fieldID]))))
+			locationBS := s.PostingsLocs[pid]
+			if len(tokenFreq.Locations) > 0 {
+				locationBS.AddInt(int(docNum))
+				for _, loc := range tokenFreq.Locations {
+					var locf = fieldID
+					if loc.Field != "" {
+						locf = uint16(s.getOrDefineField(loc.Field))
+					}
+					s.Locfields[pid] = append(s.Locfields[pid], locf)
+					s.Locstarts[pid] = append(s.Locstarts[pid], uint64(loc.Start))
+					s.Locends[pid] = append(s.Locends[pid], uint64(loc.End))
+					s.Locpos[pid] = append(s.Locpos[pid], uint64(loc.Position))
+					if len(loc.ArrayPositions) > 0 {
+						s.Locarraypos[pid] = append(s.Locarraypos[pid], loc.ArrayPositions)
+					} else {
+						s.Locarraypos[pid] = append(s.Locarraypos[pid], nil)
+					}
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// Build finalizes the segment under construction: each field's DictKeys
+// are sorted (deferred until now so AddDocument can simply append) and
+// the segment's in-memory size is recomputed, mirroring the bookkeeping
+// NewFromAnalyzedDocs performs after its two batch passes. The returned
+// Segment contains the same documents, terms, postings, norms and
+// locations as one built by NewFromAnalyzedDocs from the same documents
+// in the same order - see the SegmentBuilder doc comment for why that
+// does not extend to an identical pid assignment or on-disk byte layout.
+func (b *SegmentBuilder) Build() *Segment {
+	for _, dict := range b.s.DictKeys {
+		sort.Strings(dict)
+	}
+
+	b.s.updateSizeInBytes()
+
+	return b.s
+}
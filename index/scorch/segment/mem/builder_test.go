@@ -0,0 +1,109 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mem
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/blevesearch/bleve/analysis"
+	"github.com/blevesearch/bleve/document"
+	"github.com/blevesearch/bleve/index"
+)
+
+func buildAnalysisResult(id string, text string) *index.AnalysisResult {
+	doc := document.NewDocument(id)
+	doc.AddField(document.NewTextFieldWithIndexingOptions("name", nil, []byte(text),
+		document.IndexField|document.StoreField))
+
+	return &index.AnalysisResult{
+		Document: doc,
+		Analyzed: []analysis.TokenFrequencies{
+			analysis.TokenFrequency(analysis.TokenStream{
+				&analysis.Token{Term: []byte(text), Start: 0, End: len(text), Position: 1},
+			}, nil, true),
+		},
+		Length: []int{1},
+	}
+}
+
+// TestBuilderMatchesNewFromAnalyzedDocs checks that a segment assembled
+// incrementally via NewBuilder/AddDocument/Build contains the same
+// documents, terms and postings as one built in a single batch via
+// NewFromAnalyzedDocs, even though the two may assign postings-list ids
+// differently (see the SegmentBuilder doc comment).
+func TestBuilderMatchesNewFromAnalyzedDocs(t *testing.T) {
+	results := []*index.AnalysisResult{
+		buildAnalysisResult("a", "dog"),
+		buildAnalysisResult("b", "cat"),
+		buildAnalysisResult("c", "dog"),
+	}
+
+	batch := NewFromAnalyzedDocs(results)
+
+	b := NewBuilder()
+	for _, result := range results {
+		if err := b.AddDocument(result); err != nil {
+			t.Fatalf("AddDocument: %v", err)
+		}
+	}
+	streamed := b.Build()
+
+	if batch.Count() != streamed.Count() {
+		t.Fatalf("doc count mismatch: batch=%d streamed=%d", batch.Count(), streamed.Count())
+	}
+
+	if got, want := sortedFields(streamed), sortedFields(batch); !equalStrings(got, want) {
+		t.Fatalf("field set mismatch: got %v, want %v", got, want)
+	}
+
+	for fieldID, terms := range batch.DictKeys {
+		wantTerms := append([]string{}, terms...)
+		gotTerms := append([]string{}, streamed.DictKeys[fieldID]...)
+		sort.Strings(wantTerms)
+		sort.Strings(gotTerms)
+		if !equalStrings(gotTerms, wantTerms) {
+			t.Fatalf("field %d terms mismatch: got %v, want %v", fieldID, gotTerms, wantTerms)
+		}
+
+		for _, term := range wantTerms {
+			wantPid := batch.Dicts[fieldID][term] - 1
+			gotPid := streamed.Dicts[fieldID][term] - 1
+			wantCount := batch.Postings[wantPid].GetCardinality()
+			gotCount := streamed.Postings[gotPid].GetCardinality()
+			if wantCount != gotCount {
+				t.Fatalf("term %q in field %d: got %d postings, want %d", term, fieldID, gotCount, wantCount)
+			}
+		}
+	}
+}
+
+func sortedFields(s *Segment) []string {
+	fields := append([]string{}, s.FieldsInv...)
+	sort.Strings(fields)
+	return fields
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
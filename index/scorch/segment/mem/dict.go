@@ -0,0 +1,152 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mem
+
+import (
+	"sort"
+
+	"github.com/RoaringBitmap/roaring"
+	"github.com/blevesearch/bleve/index/scorch/segment"
+)
+
+// Dictionary implements segment.TermDictionary over one field of a
+// mem.Segment, using the already-sorted s.DictKeys[fieldID] slice to
+// drive iteration.
+type Dictionary struct {
+	segment *Segment
+	field   string
+	fieldID uint16
+	known   bool
+}
+
+// Dictionary returns the term dictionary for field. A field that does
+// not occur in this segment yields an empty dictionary rather than an
+// error, matching how callers typically probe multiple segments for a
+// field that may not be present in all of them.
+func (s *Segment) Dictionary(field string) (segment.TermDictionary, error) {
+	d := &Dictionary{
+		segment: s,
+		field:   field,
+	}
+	fieldIDPlus1, ok := s.FieldsMap[field]
+	// A rolled-back segment's FieldsMap is now an independent copy (see
+	// RollbackTo), but bounds-check against Dicts/FieldsInv anyway: a
+	// FieldsMap hit for a fieldID beyond what this segment's Dicts/
+	// FieldsInv actually cover can only mean FieldsMap points past data
+	// this segment has, which must not be treated as "known".
+	if ok && int(fieldIDPlus1-1) < len(s.Dicts) && int(fieldIDPlus1-1) < len(s.FieldsInv) {
+		d.fieldID = fieldIDPlus1 - 1
+		d.known = true
+		if err := s.ensureFieldDict(d.fieldID); err != nil {
+			return nil, err
+		}
+	}
+	return d, nil
+}
+
+// PostingsList returns the postings for term, or an empty postings list
+// if term does not occur in this field. docNums set in except are
+// omitted from iteration.
+func (d *Dictionary) PostingsList(term string, except *roaring.Bitmap) (segment.PostingsList, error) {
+	if !d.known {
+		return &PostingsList{}, nil
+	}
+	pidPlus1, ok := d.segment.Dicts[d.fieldID][term]
+	if !ok {
+		return &PostingsList{}, nil
+	}
+	return &PostingsList{
+		segment: d.segment,
+		pid:     pidPlus1 - 1,
+		except:  except,
+	}, nil
+}
+
+// Iterator walks every term in this field, in sorted order.
+func (d *Dictionary) Iterator() segment.DictionaryIterator {
+	if !d.known {
+		return &DictionaryIterator{}
+	}
+	return &DictionaryIterator{
+		d:     d,
+		terms: d.segment.DictKeys[d.fieldID],
+	}
+}
+
+// PrefixIterator walks every term in this field with the given prefix, in
+// sorted order.
+func (d *Dictionary) PrefixIterator(prefix string) segment.DictionaryIterator {
+	it := d.Iterator().(*DictionaryIterator)
+	if !d.known {
+		return it
+	}
+	it.pos = sort.SearchStrings(it.terms, prefix)
+	it.prefix = prefix
+	return it
+}
+
+// RangeIterator walks every term in this field in [start, end), in sorted
+// order.
+func (d *Dictionary) RangeIterator(start, end string) segment.DictionaryIterator {
+	it := d.Iterator().(*DictionaryIterator)
+	if !d.known {
+		return it
+	}
+	it.pos = sort.SearchStrings(it.terms, start)
+	it.end = end
+	return it
+}
+
+// DictionaryIterator walks a Dictionary's sorted terms, optionally bounded
+// by a prefix or an exclusive end term.
+type DictionaryIterator struct {
+	d      *Dictionary
+	terms  []string
+	pos    int
+	prefix string
+	end    string
+}
+
+// Next returns the next term in the iteration, or (nil, nil) when
+// exhausted.
+func (i *DictionaryIterator) Next() (*segment.DictEntry, error) {
+	if i.d == nil || i.pos >= len(i.terms) {
+		return nil, nil
+	}
+	term := i.terms[i.pos]
+	if i.prefix != "" && !hasPrefix(term, i.prefix) {
+		return nil, nil
+	}
+	if i.end != "" && term >= i.end {
+		return nil, nil
+	}
+	i.pos++
+
+	pidPlus1 := i.d.segment.Dicts[i.d.fieldID][term]
+	bm := i.d.segment.Postings[pidPlus1-1]
+	if i.d.segment.Deleted != nil {
+		bm = roaring.AndNot(bm, i.d.segment.Deleted)
+	}
+	count := bm.GetCardinality()
+
+	return &segment.DictEntry{
+		Term:  term,
+		Count: count,
+	}, nil
+}
+
+func hasPrefix(s, prefix string) bool {
+	return len(s) >= len(prefix) && s[:len(prefix)] == prefix
+}
@@ -0,0 +1,176 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mem
+
+import (
+	"fmt"
+
+	"github.com/RoaringBitmap/roaring"
+)
+
+// DeleteDocument marks docNum as deleted. Deleted docNums stay in
+// Stored/Postings/etc (mem never rewrites data in place), but are
+// consulted by PostingsList.Iterator so deleted documents stop
+// contributing hits. This gives mem the same soft-delete semantics
+// scorch already relies on for zap segments.
+func (s *Segment) DeleteDocument(docNum uint64) {
+	if s.Deleted == nil {
+		s.Deleted = roaring.New()
+	}
+	s.Deleted.AddInt(int(docNum))
+	s.updateSizeInBytes()
+}
+
+// RollbackTo returns a new segment containing only the mutations for
+// docNums strictly below docNum, discarding everything at or after that
+// watermark. It is the mem analogue of scorch's rollback snapshots: a
+// caller that speculatively appended documents to a segment and then
+// decided to discard the tail can roll back to the watermark it recorded
+// before appending, rather than rebuilding from scratch.
+//
+// Field and postings-list ids are preserved across the rollback (a term's
+// pid in the returned segment is the same as its pid in s), since
+// renumbering would require rewriting every other posting that happens to
+// share the same global pid counter. A term whose only occurrences were
+// at or after docNum therefore survives in the rolled-back segment's
+// Dicts/DictKeys with an empty postings list, rather than being removed
+// outright; callers iterating such a term simply see zero postings. This
+// mirrors initializeDict's own invariant that a Dicts entry, once
+// assigned, always has a corresponding (possibly empty) Postings slot.
+func (s *Segment) RollbackTo(docNum uint64) (*Segment, error) {
+	if docNum > uint64(len(s.Stored)) {
+		return nil, fmt.Errorf("mem: cannot roll back to docNum %d, segment only has %d documents", docNum, len(s.Stored))
+	}
+
+	rv := New()
+
+	// getOrDefineField adds a new field to s.FieldsMap/s.FieldsInv (not
+	// just to an existing field's entry) the same way getOrDefinePosting
+	// adds new terms to s.Dicts/s.DictKeys, so FieldsMap needs the same
+	// independent copy as Dicts/DictKeys below: aliasing it would let a
+	// later getOrDefineField call on s register a field name in rv's map
+	// that rv.FieldsInv/Dicts/DictKeys were never grown to cover.
+	rv.FieldsMap = make(map[string]uint16, len(s.FieldsMap))
+	for name, fieldID := range s.FieldsMap {
+		rv.FieldsMap[name] = fieldID
+	}
+	rv.FieldsInv = append([]string{}, s.FieldsInv...)
+
+	rv.DocValueFields = make(map[uint16]bool, len(s.DocValueFields))
+	for fieldID := range s.DocValueFields {
+		rv.DocValueFields[fieldID] = true
+	}
+
+	rv.Stored = append([]map[uint16][][]byte{}, s.Stored[:docNum]...)
+	rv.StoredTypes = append([]map[uint16][]byte{}, s.StoredTypes[:docNum]...)
+	rv.StoredPos = append([]map[uint16][][]uint64{}, s.StoredPos[:docNum]...)
+
+	// mem is documented as a mutable write buffer: s keeps receiving
+	// AddDocument calls after RollbackTo returns, and getOrDefineField/
+	// getOrDefinePosting mutate s.Dicts/s.DictKeys in place (including
+	// for fields that already existed at rollback time). Aliasing those
+	// here would let a later call on s silently add postings-ids to rv's
+	// dictionary that are out of range for rv's (frozen) Postings, so
+	// each field's map and key slice is copied instead.
+	rv.Dicts = make([]map[string]uint64, len(s.Dicts))
+	rv.DictKeys = make([][]string, len(s.DictKeys))
+	for fieldID, dict := range s.Dicts {
+		fieldDict := make(map[string]uint64, len(dict))
+		for term, pid := range dict {
+			fieldDict[term] = pid
+		}
+		rv.Dicts[fieldID] = fieldDict
+		rv.DictKeys[fieldID] = append([]string{}, s.DictKeys[fieldID]...)
+	}
+
+	rv.Postings = make([]*roaring.Bitmap, len(s.Postings))
+	rv.PostingsLocs = make([]*roaring.Bitmap, len(s.Postings))
+	rv.Freqs = make([][]uint64, len(s.Postings))
+	rv.Norms = make([][]float32, len(s.Postings))
+	rv.Locfields = make([][]uint16, len(s.Postings))
+	rv.Locstarts = make([][]uint64, len(s.Postings))
+	rv.Locends = make([][]uint64, len(s.Postings))
+	rv.Locpos = make([][]uint64, len(s.Postings))
+	rv.Locarraypos = make([][][]uint64, len(s.Postings))
+
+	for pid := range s.Postings {
+		s.rollbackPosting(rv, pid, docNum)
+	}
+
+	if s.Deleted != nil {
+		kept := roaring.New()
+		kept.AddRange(0, docNum)
+		rv.Deleted = roaring.And(s.Deleted, kept)
+	}
+
+	rv.updateSizeInBytes()
+
+	return rv, nil
+}
+
+// rollbackPosting rebuilds pid's bitmap plus its parallel Freqs/Norms/
+// Loc* arrays in rv, keeping only the entries for docNums below cutoff.
+// It relies on the same invariant PostingsIterator does: Freqs[pid][i]
+// and Norms[pid][i] line up with the i-th docNum in Postings[pid]'s
+// iteration order, and a docNum present in PostingsLocs[pid] contributes
+// exactly Freqs[pid][i] consecutive entries to the Loc* arrays.
+func (s *Segment) rollbackPosting(rv *Segment, pid int, cutoff uint64) {
+	newBitmap := roaring.New()
+	newLocBitmap := roaring.New()
+	var newFreqs []uint64
+	var newNorms []float32
+	var newLocfields []uint16
+	var newLocstarts, newLocends, newLocpos []uint64
+	var newLocarraypos [][]uint64
+
+	hasLocs := s.PostingsLocs[pid]
+	locIdx := 0
+
+	it := s.Postings[pid].Iterator()
+	for i := 0; it.HasNext(); i++ {
+		d := uint64(it.Next())
+		freq := s.Freqs[pid][i]
+		locCount := 0
+		if hasLocs.Contains(uint32(d)) {
+			locCount = int(freq)
+		}
+
+		if d < cutoff {
+			newBitmap.AddInt(int(d))
+			newFreqs = append(newFreqs, freq)
+			newNorms = append(newNorms, s.Norms[pid][i])
+			if locCount > 0 {
+				newLocBitmap.AddInt(int(d))
+				newLocfields = append(newLocfields, s.Locfields[pid][locIdx:locIdx+locCount]...)
+				newLocstarts = append(newLocstarts, s.Locstarts[pid][locIdx:locIdx+locCount]...)
+				newLocends = append(newLocends, s.Locends[pid][locIdx:locIdx+locCount]...)
+				newLocpos = append(newLocpos, s.Locpos[pid][locIdx:locIdx+locCount]...)
+				newLocarraypos = append(newLocarraypos, s.Locarraypos[pid][locIdx:locIdx+locCount]...)
+			}
+		}
+
+		locIdx += locCount
+	}
+
+	rv.Postings[pid] = newBitmap
+	rv.PostingsLocs[pid] = newLocBitmap
+	rv.Freqs[pid] = newFreqs
+	rv.Norms[pid] = newNorms
+	rv.Locfields[pid] = newLocfields
+	rv.Locstarts[pid] = newLocstarts
+	rv.Locends[pid] = newLocends
+	rv.Locpos[pid] = newLocpos
+	rv.Locarraypos[pid] = newLocarraypos
+}
@@ -0,0 +1,201 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mem
+
+import (
+	"testing"
+
+	"github.com/blevesearch/bleve/analysis"
+	"github.com/blevesearch/bleve/document"
+	"github.com/blevesearch/bleve/index"
+)
+
+// buildAnalysisResultWithField is like buildAnalysisResult but lets the
+// caller pick the field name, so a test can introduce a field that did
+// not exist in the segment before.
+func buildAnalysisResultWithField(id, field, text string) *index.AnalysisResult {
+	doc := document.NewDocument(id)
+	doc.AddField(document.NewTextFieldWithIndexingOptions(field, nil, []byte(text),
+		document.IndexField|document.StoreField))
+
+	return &index.AnalysisResult{
+		Document: doc,
+		Analyzed: []analysis.TokenFrequencies{
+			analysis.TokenFrequency(analysis.TokenStream{
+				&analysis.Token{Term: []byte(text), Start: 0, End: len(text), Position: 1},
+			}, nil, true),
+		},
+		Length: []int{1},
+	}
+}
+
+func TestDeleteDocumentExcludesFromPostings(t *testing.T) {
+	results := []*index.AnalysisResult{
+		buildAnalysisResult("a", "dog"),
+		buildAnalysisResult("b", "dog"),
+	}
+	s := NewFromAnalyzedDocs(results)
+
+	dict, err := s.Dictionary("name")
+	if err != nil {
+		t.Fatalf("Dictionary: %v", err)
+	}
+	postings, err := dict.PostingsList("dog", nil)
+	if err != nil {
+		t.Fatalf("PostingsList: %v", err)
+	}
+	if postings.Count() != 2 {
+		t.Fatalf("got %d postings before delete, want 2", postings.Count())
+	}
+
+	s.DeleteDocument(0)
+
+	postings, err = dict.PostingsList("dog", nil)
+	if err != nil {
+		t.Fatalf("PostingsList after delete: %v", err)
+	}
+	if postings.Count() != 1 {
+		t.Fatalf("got %d postings after delete, want 1", postings.Count())
+	}
+
+	remaining, err := postings.Iterator().Next()
+	if err != nil {
+		t.Fatalf("Iterator.Next: %v", err)
+	}
+	if remaining == nil || remaining.Number() != 1 {
+		t.Fatalf("got %v, want docNum 1 to remain", remaining)
+	}
+
+	iter := dict.Iterator()
+	entry, err := iter.Next()
+	if err != nil {
+		t.Fatalf("DictionaryIterator.Next: %v", err)
+	}
+	if entry == nil || entry.Count != 1 {
+		t.Fatalf("got dict entry %+v, want Count=1 after delete", entry)
+	}
+}
+
+// TestRollbackToThenContinueWriting guards against the rollback snapshot
+// being corrupted by later writes to the live segment: it rolls back to a
+// watermark, keeps adding documents (including new terms on a field that
+// already existed at the watermark) and checks that the rolled-back
+// snapshot still reports its original, unaffected contents.
+func TestRollbackToThenContinueWriting(t *testing.T) {
+	b := NewBuilder()
+	if err := b.AddDocument(buildAnalysisResult("a", "dog")); err != nil {
+		t.Fatalf("AddDocument: %v", err)
+	}
+	if err := b.AddDocument(buildAnalysisResult("b", "cat")); err != nil {
+		t.Fatalf("AddDocument: %v", err)
+	}
+
+	live := b.Build()
+	rv, err := live.RollbackTo(live.Count())
+	if err != nil {
+		t.Fatalf("RollbackTo: %v", err)
+	}
+
+	// Keep writing to the live segment after the snapshot was taken,
+	// including a brand new term on the "name" field that rv already
+	// knew about.
+	if err := b.AddDocument(buildAnalysisResult("c", "fox")); err != nil {
+		t.Fatalf("AddDocument after rollback: %v", err)
+	}
+
+	if rv.Count() != 2 {
+		t.Fatalf("got %d documents in rollback snapshot, want 2", rv.Count())
+	}
+
+	dict, err := rv.Dictionary("name")
+	if err != nil {
+		t.Fatalf("Dictionary on rollback snapshot: %v", err)
+	}
+	iter := dict.Iterator()
+	var terms []string
+	for {
+		entry, err := iter.Next()
+		if err != nil {
+			t.Fatalf("DictionaryIterator.Next: %v", err)
+		}
+		if entry == nil {
+			break
+		}
+		terms = append(terms, entry.Term)
+		postings, err := dict.PostingsList(entry.Term, nil)
+		if err != nil {
+			t.Fatalf("PostingsList(%q): %v", entry.Term, err)
+		}
+		if postings.Count() != 1 {
+			t.Fatalf("term %q: got %d postings, want 1", entry.Term, postings.Count())
+		}
+	}
+	if len(terms) != 2 {
+		t.Fatalf("got terms %v in rollback snapshot, want 2 (dog, cat) and no fox", terms)
+	}
+
+	// The new term must not have leaked into the snapshot's dictionary.
+	postings, err := dict.PostingsList("fox", nil)
+	if err != nil {
+		t.Fatalf("PostingsList(fox): %v", err)
+	}
+	if postings.Count() != 0 {
+		t.Fatalf("got %d postings for \"fox\" in rollback snapshot, want 0", postings.Count())
+	}
+}
+
+// TestRollbackToThenAddNewField guards against the specific corruption a
+// shared FieldsMap would cause: a field introduced by the live segment
+// after RollbackTo must not become visible (let alone "known" with an
+// out-of-range fieldID) in the rollback snapshot.
+func TestRollbackToThenAddNewField(t *testing.T) {
+	b := NewBuilder()
+	if err := b.AddDocument(buildAnalysisResult("a", "dog")); err != nil {
+		t.Fatalf("AddDocument: %v", err)
+	}
+
+	live := b.Build()
+	rv, err := live.RollbackTo(live.Count())
+	if err != nil {
+		t.Fatalf("RollbackTo: %v", err)
+	}
+
+	// Introduce a brand new field on the live segment after the snapshot
+	// was taken.
+	if err := b.AddDocument(buildAnalysisResultWithField("b", "bio", "fox")); err != nil {
+		t.Fatalf("AddDocument after rollback: %v", err)
+	}
+
+	dict, err := rv.Dictionary("bio")
+	if err != nil {
+		t.Fatalf("Dictionary(bio) on rollback snapshot: %v", err)
+	}
+	it := dict.Iterator()
+	entry, err := it.Next()
+	if err != nil {
+		t.Fatalf("Iterator.Next: %v", err)
+	}
+	if entry != nil {
+		t.Fatalf("got dict entry %+v for field unknown to the rollback snapshot, want none", entry)
+	}
+
+	postings, err := dict.PostingsList("fox", nil)
+	if err != nil {
+		t.Fatalf("PostingsList(fox): %v", err)
+	}
+	if postings.Count() != 0 {
+		t.Fatalf("got %d postings for \"fox\" via a field unknown to the rollback snapshot, want 0", postings.Count())
+	}
+}
@@ -0,0 +1,602 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mem
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"sync"
+
+	"github.com/RoaringBitmap/roaring"
+	mmap "github.com/blevesearch/mmap-go"
+)
+
+// On disk, a mem segment is laid out as:
+//
+//	header:   magic, version
+//	postings: for pid 0..n-1, a self-contained blob of the pid's
+//	          Postings/PostingsLocs bitmaps, Freqs, Norms and Loc* arrays
+//	fields:   FieldsInv, then per field the sorted, prefix-compressed
+//	          DictKeys and, for each term, its pid and the offset of that
+//	          pid's postings blob from the start of the postings section
+//	stored:   per docNum, the Stored/StoredTypes/StoredPos tuples, and the
+//	          DocValueFields set
+//	footer:   section offsets, a CRC32 of everything preceding it, and a
+//	          trailing magic so Open can find it by seeking from EOF
+//
+// Postings are written first, and by themselves, so that Open can mmap
+// the file and hand out roaring.Bitmap values backed directly by the
+// mapped bytes (via Bitmap.FromBuffer) instead of copying them.
+const (
+	persistMagic       = 0xB1EEEB17
+	persistFooterMagic = 0xFEEDFEED
+	footerSize         = 8 + 8 + 8 + 4 + 4 // 3 offsets + crc32 + magic
+)
+
+// WriteTo serializes the segment to w in the format described above,
+// returning the number of bytes written.
+func (s *Segment) WriteTo(w io.Writer) (int64, error) {
+	crc := crc32.NewIEEE()
+	bw := bufio.NewWriter(io.MultiWriter(w, crc))
+	cw := &countingWriter{w: bw}
+
+	if err := writeUint32(cw, persistMagic); err != nil {
+		return cw.n, err
+	}
+	if err := writeUint32(cw, Version); err != nil {
+		return cw.n, err
+	}
+
+	postingsOffset := cw.n
+	postingOffsets, err := s.writePostings(cw)
+	if err != nil {
+		return cw.n, err
+	}
+
+	fieldsOffset := cw.n
+	if err := s.writeFields(cw, postingOffsets); err != nil {
+		return cw.n, err
+	}
+
+	storedOffset := cw.n
+	if err := s.writeStored(cw); err != nil {
+		return cw.n, err
+	}
+
+	if err := bw.Flush(); err != nil {
+		return cw.n, err
+	}
+
+	// footer is written directly to w, outside the crc
+	fw := &countingWriter{w: w, n: cw.n}
+	if err := writeUint64(fw, uint64(postingsOffset)); err != nil {
+		return fw.n, err
+	}
+	if err := writeUint64(fw, uint64(fieldsOffset)); err != nil {
+		return fw.n, err
+	}
+	if err := writeUint64(fw, uint64(storedOffset)); err != nil {
+		return fw.n, err
+	}
+	if err := writeUint32(fw, crc.Sum32()); err != nil {
+		return fw.n, err
+	}
+	if err := writeUint32(fw, persistFooterMagic); err != nil {
+		return fw.n, err
+	}
+
+	return fw.n, nil
+}
+
+func (s *Segment) writePostings(w io.Writer) ([]int64, error) {
+	offsets := make([]int64, len(s.Postings))
+	cw := &countingWriter{w: w}
+
+	for pid := range s.Postings {
+		offsets[pid] = cw.n
+
+		if err := writeBitmap(cw, s.Postings[pid]); err != nil {
+			return nil, err
+		}
+		if err := writeBitmap(cw, s.PostingsLocs[pid]); err != nil {
+			return nil, err
+		}
+		if err := writeUint64s(cw, s.Freqs[pid]); err != nil {
+			return nil, err
+		}
+		if err := writeVarint(cw, uint64(len(s.Norms[pid]))); err != nil {
+			return nil, err
+		}
+		for _, norm := range s.Norms[pid] {
+			if err := writeUint32(cw, math.Float32bits(norm)); err != nil {
+				return nil, err
+			}
+		}
+
+		if err := writeVarint(cw, uint64(len(s.Locfields[pid]))); err != nil {
+			return nil, err
+		}
+		for i := range s.Locfields[pid] {
+			if err := writeUint16(cw, s.Locfields[pid][i]); err != nil {
+				return nil, err
+			}
+			if err := writeVarint(cw, s.Locstarts[pid][i]); err != nil {
+				return nil, err
+			}
+			if err := writeVarint(cw, s.Locends[pid][i]); err != nil {
+				return nil, err
+			}
+			if err := writeVarint(cw, s.Locpos[pid][i]); err != nil {
+				return nil, err
+			}
+			if err := writeUint64s(cw, s.Locarraypos[pid][i]); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return offsets, nil
+}
+
+func (s *Segment) writeFields(w io.Writer, postingOffsets []int64) error {
+	if err := writeVarint(w, uint64(len(s.FieldsInv))); err != nil {
+		return err
+	}
+	for _, name := range s.FieldsInv {
+		if err := writeBytes(w, []byte(name)); err != nil {
+			return err
+		}
+	}
+
+	for fieldID, terms := range s.DictKeys {
+		if err := writeVarint(w, uint64(len(terms))); err != nil {
+			return err
+		}
+		prev := ""
+		for _, term := range terms {
+			shared := commonPrefixLen(prev, term)
+			if err := writeVarint(w, uint64(shared)); err != nil {
+				return err
+			}
+			if err := writeBytes(w, []byte(term[shared:])); err != nil {
+				return err
+			}
+			pid := s.Dicts[fieldID][term] - 1
+			if err := writeVarint(w, pid); err != nil {
+				return err
+			}
+			if err := writeVarint(w, uint64(postingOffsets[pid])); err != nil {
+				return err
+			}
+			prev = term
+		}
+	}
+
+	return nil
+}
+
+func (s *Segment) writeStored(w io.Writer) error {
+	if err := writeVarint(w, uint64(len(s.Stored))); err != nil {
+		return err
+	}
+	for docNum := range s.Stored {
+		fieldIDs := make([]int, 0, len(s.Stored[docNum]))
+		for fieldID := range s.Stored[docNum] {
+			fieldIDs = append(fieldIDs, int(fieldID))
+		}
+		sort.Ints(fieldIDs)
+
+		if err := writeVarint(w, uint64(len(fieldIDs))); err != nil {
+			return err
+		}
+		for _, fid := range fieldIDs {
+			fieldID := uint16(fid)
+			values := s.Stored[docNum][fieldID]
+			if err := writeUint16(w, fieldID); err != nil {
+				return err
+			}
+			if err := writeVarint(w, uint64(len(values))); err != nil {
+				return err
+			}
+			for i, val := range values {
+				if _, err := w.Write([]byte{s.StoredTypes[docNum][fieldID][i]}); err != nil {
+					return err
+				}
+				if err := writeUint64s(w, s.StoredPos[docNum][fieldID][i]); err != nil {
+					return err
+				}
+				if err := writeBytes(w, val); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	docValueFieldIDs := make([]int, 0, len(s.DocValueFields))
+	for fieldID := range s.DocValueFields {
+		docValueFieldIDs = append(docValueFieldIDs, int(fieldID))
+	}
+	sort.Ints(docValueFieldIDs)
+	if err := writeVarint(w, uint64(len(docValueFieldIDs))); err != nil {
+		return err
+	}
+	for _, fid := range docValueFieldIDs {
+		if err := writeUint16(w, uint16(fid)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Open reads a mem segment previously written with WriteTo back from
+// path. The file is mmap'd and the file handle closed before Open returns;
+// the mapping stays for the life of the returned Segment (see Close). The
+// roaring.Bitmap postings and the raw bytes
+// backing stored field values alias the mapped memory directly rather
+// than being copied, since those are the bulk of a segment's size. The
+// comparatively small Freqs/Norms/Loc* arrays are decoded eagerly into
+// ordinary Go slices. The term dictionary - which for a large vocabulary
+// can itself be a significant fraction of a segment's size - is not
+// decoded at Open time at all: readFields only delimits each field's
+// encoded dictionary bytes, and ensureFieldDict decodes a field's terms
+// the first time that field is actually queried (see its doc comment).
+func Open(path string) (*Segment, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("mem: open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	mm, err := mmap.Map(f, mmap.RDONLY, 0)
+	if err != nil {
+		return nil, fmt.Errorf("mem: mmap %s: %w", path, err)
+	}
+
+	data := []byte(mm)
+	if len(data) < 8+footerSize {
+		mm.Unmap()
+		return nil, fmt.Errorf("mem: %s is too small to be a segment", path)
+	}
+
+	footer := data[len(data)-footerSize:]
+	postingsOffset := int64(binary.LittleEndian.Uint64(footer[0:8]))
+	fieldsOffset := int64(binary.LittleEndian.Uint64(footer[8:16]))
+	storedOffset := int64(binary.LittleEndian.Uint64(footer[16:24]))
+	wantCRC := binary.LittleEndian.Uint32(footer[24:28])
+	footerMagic := binary.LittleEndian.Uint32(footer[28:32])
+	if footerMagic != persistFooterMagic {
+		mm.Unmap()
+		return nil, fmt.Errorf("mem: %s has an invalid footer", path)
+	}
+
+	body := data[:len(data)-footerSize]
+	gotCRC := crc32.ChecksumIEEE(body)
+	if gotCRC != wantCRC {
+		mm.Unmap()
+		return nil, fmt.Errorf("mem: %s failed its checksum", path)
+	}
+
+	r := newByteReader(body)
+	magic, err := r.readUint32()
+	if err != nil || magic != persistMagic {
+		mm.Unmap()
+		return nil, fmt.Errorf("mem: %s has an invalid header", path)
+	}
+	if _, err := r.readUint32(); err != nil { // version, currently unused
+		mm.Unmap()
+		return nil, err
+	}
+
+	s := New()
+	s.mmap = mm
+
+	if err := s.readFields(newByteReader(body[fieldsOffset:storedOffset])); err != nil {
+		mm.Unmap()
+		return nil, err
+	}
+	if err := s.readPostings(body[postingsOffset:fieldsOffset]); err != nil {
+		mm.Unmap()
+		return nil, err
+	}
+	if err := s.readStored(newByteReader(body[storedOffset:])); err != nil {
+		mm.Unmap()
+		return nil, err
+	}
+
+	s.updateSizeInBytes()
+
+	return s, nil
+}
+
+// readFields decodes the field names and, for each field, delimits its
+// encoded dictionary region without decoding any of its terms - no
+// string is allocated and no map is built here. The bulk of a segment's
+// vocabulary is therefore not copied out of the mmap'd file until (and
+// unless) ensureFieldDict actually needs it.
+func (s *Segment) readFields(r *byteReader) error {
+	numFields, err := r.readVarint()
+	if err != nil {
+		return err
+	}
+	s.FieldsInv = make([]string, 0, numFields)
+	s.FieldsMap = make(map[string]uint16, numFields)
+	s.Dicts = make([]map[string]uint64, numFields)
+	s.DictKeys = make([][]string, numFields)
+	s.dictRegions = make([][]byte, numFields)
+	s.dictNumTerms = make([]uint64, numFields)
+	s.dictOnce = make([]sync.Once, numFields)
+	for i := uint64(0); i < numFields; i++ {
+		name, err := r.readString()
+		if err != nil {
+			return err
+		}
+		s.FieldsInv = append(s.FieldsInv, name)
+		s.FieldsMap[name] = uint16(i) + 1
+	}
+
+	for fieldID := uint64(0); fieldID < numFields; fieldID++ {
+		numTerms, err := r.readVarint()
+		if err != nil {
+			return err
+		}
+
+		start := r.pos
+		for i := uint64(0); i < numTerms; i++ {
+			if _, err := r.readVarint(); err != nil { // shared prefix length
+				return err
+			}
+			if _, err := r.readBytes(); err != nil { // suffix, not retained here
+				return err
+			}
+			if _, err := r.readVarint(); err != nil { // pid
+				return err
+			}
+			// the posting's offset within the postings section, written so a future
+			// random-access reader can seek directly to one term's
+			// postings; readPostings below instead decodes the whole
+			// region once, sequentially, so it is skipped here.
+			if _, err := r.readVarint(); err != nil {
+				return err
+			}
+		}
+
+		s.dictRegions[fieldID] = r.b[start:r.pos]
+		s.dictNumTerms[fieldID] = numTerms
+	}
+
+	return nil
+}
+
+// ensureFieldDict decodes fieldID's term dictionary out of its raw,
+// mmap'd byte region the first time it's actually needed, memoizing the
+// result in Dicts[fieldID]/DictKeys[fieldID]. It is a no-op for segments
+// that were built in memory rather than opened from disk.
+func (s *Segment) ensureFieldDict(fieldID uint16) error {
+	if int(fieldID) >= len(s.dictRegions) || s.dictRegions[fieldID] == nil {
+		return nil
+	}
+
+	var decodeErr error
+	s.dictOnce[fieldID].Do(func() {
+		numTerms := s.dictNumTerms[fieldID]
+		dict := make(map[string]uint64, numTerms)
+		keys := make([]string, 0, numTerms)
+
+		r := newByteReader(s.dictRegions[fieldID])
+		prev := ""
+		for i := uint64(0); i < numTerms; i++ {
+			shared, err := r.readVarint()
+			if err != nil {
+				decodeErr = err
+				return
+			}
+			suffix, err := r.readString()
+			if err != nil {
+				decodeErr = err
+				return
+			}
+			term := prev[:shared] + suffix
+			pid, err := r.readVarint()
+			if err != nil {
+				decodeErr = err
+				return
+			}
+			if _, err := r.readVarint(); err != nil { // posting offset, unused
+				decodeErr = err
+				return
+			}
+			dict[term] = pid + 1
+			keys = append(keys, term)
+			prev = term
+		}
+
+		s.Dicts[fieldID] = dict
+		s.DictKeys[fieldID] = keys
+	})
+	return decodeErr
+}
+
+func (s *Segment) readPostings(region []byte) error {
+	numPostings := 0
+	for _, n := range s.dictNumTerms {
+		numPostings += int(n)
+	}
+
+	s.Postings = make([]*roaring.Bitmap, numPostings)
+	s.PostingsLocs = make([]*roaring.Bitmap, numPostings)
+	s.Freqs = make([][]uint64, numPostings)
+	s.Norms = make([][]float32, numPostings)
+	s.Locfields = make([][]uint16, numPostings)
+	s.Locstarts = make([][]uint64, numPostings)
+	s.Locends = make([][]uint64, numPostings)
+	s.Locpos = make([][]uint64, numPostings)
+	s.Locarraypos = make([][][]uint64, numPostings)
+
+	r := newByteReader(region)
+	for pid := 0; pid < numPostings; pid++ {
+		bm, err := r.readBitmap()
+		if err != nil {
+			return err
+		}
+		s.Postings[pid] = bm
+
+		locBM, err := r.readBitmap()
+		if err != nil {
+			return err
+		}
+		s.PostingsLocs[pid] = locBM
+
+		freqs, err := r.readUint64s()
+		if err != nil {
+			return err
+		}
+		s.Freqs[pid] = freqs
+
+		numNorms, err := r.readVarint()
+		if err != nil {
+			return err
+		}
+		norms := make([]float32, numNorms)
+		for i := range norms {
+			bits, err := r.readUint32()
+			if err != nil {
+				return err
+			}
+			norms[i] = math.Float32frombits(bits)
+		}
+		s.Norms[pid] = norms
+
+		numLocs, err := r.readVarint()
+		if err != nil {
+			return err
+		}
+		s.Locfields[pid] = make([]uint16, numLocs)
+		s.Locstarts[pid] = make([]uint64, numLocs)
+		s.Locends[pid] = make([]uint64, numLocs)
+		s.Locpos[pid] = make([]uint64, numLocs)
+		s.Locarraypos[pid] = make([][]uint64, numLocs)
+		for i := uint64(0); i < numLocs; i++ {
+			field, err := r.readUint16()
+			if err != nil {
+				return err
+			}
+			start, err := r.readVarint()
+			if err != nil {
+				return err
+			}
+			end, err := r.readVarint()
+			if err != nil {
+				return err
+			}
+			pos, err := r.readVarint()
+			if err != nil {
+				return err
+			}
+			arrayPos, err := r.readUint64s()
+			if err != nil {
+				return err
+			}
+			s.Locfields[pid][i] = field
+			s.Locstarts[pid][i] = start
+			s.Locends[pid][i] = end
+			s.Locpos[pid][i] = pos
+			s.Locarraypos[pid][i] = arrayPos
+		}
+	}
+
+	return nil
+}
+
+func (s *Segment) readStored(r *byteReader) error {
+	numDocs, err := r.readVarint()
+	if err != nil {
+		return err
+	}
+	s.Stored = make([]map[uint16][][]byte, numDocs)
+	s.StoredTypes = make([]map[uint16][]byte, numDocs)
+	s.StoredPos = make([]map[uint16][][]uint64, numDocs)
+
+	for docNum := uint64(0); docNum < numDocs; docNum++ {
+		s.Stored[docNum] = map[uint16][][]byte{}
+		s.StoredTypes[docNum] = map[uint16][]byte{}
+		s.StoredPos[docNum] = map[uint16][][]uint64{}
+
+		numFields, err := r.readVarint()
+		if err != nil {
+			return err
+		}
+		for i := uint64(0); i < numFields; i++ {
+			fieldID, err := r.readUint16()
+			if err != nil {
+				return err
+			}
+			numValues, err := r.readVarint()
+			if err != nil {
+				return err
+			}
+			for j := uint64(0); j < numValues; j++ {
+				typ, err := r.readByte()
+				if err != nil {
+					return err
+				}
+				pos, err := r.readUint64s()
+				if err != nil {
+					return err
+				}
+				val, err := r.readBytes()
+				if err != nil {
+					return err
+				}
+				s.Stored[docNum][fieldID] = append(s.Stored[docNum][fieldID], val)
+				s.StoredTypes[docNum][fieldID] = append(s.StoredTypes[docNum][fieldID], typ)
+				s.StoredPos[docNum][fieldID] = append(s.StoredPos[docNum][fieldID], pos)
+			}
+		}
+	}
+
+	numDocValueFields, err := r.readVarint()
+	if err != nil {
+		return err
+	}
+	s.DocValueFields = make(map[uint16]bool, numDocValueFields)
+	for i := uint64(0); i < numDocValueFields; i++ {
+		fieldID, err := r.readUint16()
+		if err != nil {
+			return err
+		}
+		s.DocValueFields[fieldID] = true
+	}
+
+	return nil
+}
+
+func commonPrefixLen(a, b string) int {
+	n := len(a)
+	if len(b) < n {
+		n = len(b)
+	}
+	i := 0
+	for i < n && a[i] == b[i] {
+		i++
+	}
+	return i
+}
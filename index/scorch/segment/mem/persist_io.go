@@ -0,0 +1,202 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mem
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	"github.com/RoaringBitmap/roaring"
+)
+
+// countingWriter tracks how many bytes have been written through it, so
+// WriteTo can record section offsets as it streams the segment out.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+var varintBuf [binary.MaxVarintLen64]byte
+
+func writeVarint(w io.Writer, v uint64) error {
+	n := binary.PutUvarint(varintBuf[:], v)
+	_, err := w.Write(varintBuf[:n])
+	return err
+}
+
+func writeUint16(w io.Writer, v uint16) error {
+	var buf [2]byte
+	binary.LittleEndian.PutUint16(buf[:], v)
+	_, err := w.Write(buf[:])
+	return err
+}
+
+func writeUint32(w io.Writer, v uint32) error {
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], v)
+	_, err := w.Write(buf[:])
+	return err
+}
+
+func writeUint64(w io.Writer, v uint64) error {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], v)
+	_, err := w.Write(buf[:])
+	return err
+}
+
+func writeBytes(w io.Writer, b []byte) error {
+	if err := writeVarint(w, uint64(len(b))); err != nil {
+		return err
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+func writeUint64s(w io.Writer, vs []uint64) error {
+	if err := writeVarint(w, uint64(len(vs))); err != nil {
+		return err
+	}
+	for _, v := range vs {
+		if err := writeVarint(w, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeBitmap frames bm's own WriteTo encoding with a length prefix, so
+// readBitmap can slice out exactly its bytes for roaring.Bitmap.FromBuffer
+// without scanning the roaring container format itself.
+func writeBitmap(w io.Writer, bm *roaring.Bitmap) error {
+	var buf bytes.Buffer
+	if _, err := bm.WriteTo(&buf); err != nil {
+		return err
+	}
+	return writeBytes(w, buf.Bytes())
+}
+
+// byteReader decodes the primitives written above out of an in-memory
+// (typically mmap'd) byte slice, without any intermediate io.Reader
+// buffering.
+type byteReader struct {
+	b   []byte
+	pos int
+}
+
+func newByteReader(b []byte) *byteReader {
+	return &byteReader{b: b}
+}
+
+func (r *byteReader) readByte() (byte, error) {
+	if r.pos >= len(r.b) {
+		return 0, fmt.Errorf("mem: unexpected end of segment")
+	}
+	v := r.b[r.pos]
+	r.pos++
+	return v, nil
+}
+
+func (r *byteReader) readUint16() (uint16, error) {
+	if r.pos+2 > len(r.b) {
+		return 0, fmt.Errorf("mem: unexpected end of segment")
+	}
+	v := binary.LittleEndian.Uint16(r.b[r.pos:])
+	r.pos += 2
+	return v, nil
+}
+
+func (r *byteReader) readUint32() (uint32, error) {
+	if r.pos+4 > len(r.b) {
+		return 0, fmt.Errorf("mem: unexpected end of segment")
+	}
+	v := binary.LittleEndian.Uint32(r.b[r.pos:])
+	r.pos += 4
+	return v, nil
+}
+
+func (r *byteReader) readVarint() (uint64, error) {
+	v, n := binary.Uvarint(r.b[r.pos:])
+	if n <= 0 {
+		return 0, fmt.Errorf("mem: invalid varint in segment")
+	}
+	r.pos += n
+	return v, nil
+}
+
+// readBytes returns a slice of the reader's underlying buffer directly,
+// without copying; callers holding onto it are relying on the caller of
+// Open keeping the mmap alive for the life of the Segment.
+func (r *byteReader) readBytes() ([]byte, error) {
+	n, err := r.readVarint()
+	if err != nil {
+		return nil, err
+	}
+	if r.pos+int(n) > len(r.b) {
+		return nil, fmt.Errorf("mem: unexpected end of segment")
+	}
+	v := r.b[r.pos : r.pos+int(n)]
+	r.pos += int(n)
+	return v, nil
+}
+
+func (r *byteReader) readString() (string, error) {
+	b, err := r.readBytes()
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func (r *byteReader) readUint64s() ([]uint64, error) {
+	n, err := r.readVarint()
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, nil
+	}
+	vs := make([]uint64, n)
+	for i := range vs {
+		v, err := r.readVarint()
+		if err != nil {
+			return nil, err
+		}
+		vs[i] = v
+	}
+	return vs, nil
+}
+
+func (r *byteReader) readBitmap() (*roaring.Bitmap, error) {
+	b, err := r.readBytes()
+	if err != nil {
+		return nil, err
+	}
+	bm := roaring.New()
+	if len(b) > 0 {
+		if _, err := bm.FromBuffer(b); err != nil {
+			return nil, err
+		}
+	}
+	return bm, nil
+}
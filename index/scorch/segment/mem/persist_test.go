@@ -0,0 +1,100 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mem
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/blevesearch/bleve/index"
+)
+
+func TestWriteToThenOpenRoundTrip(t *testing.T) {
+	results := []*index.AnalysisResult{
+		buildAnalysisResult("a", "dog"),
+		buildAnalysisResult("b", "cat"),
+		buildAnalysisResult("c", "dog"),
+	}
+	original := NewFromAnalyzedDocs(results)
+
+	var buf bytes.Buffer
+	n, err := original.WriteTo(&buf)
+	if err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+	if n != int64(buf.Len()) {
+		t.Fatalf("WriteTo returned %d, but wrote %d bytes", n, buf.Len())
+	}
+
+	dir, err := ioutil.TempDir("", "mem-segment-test")
+	if err != nil {
+		t.Fatalf("TempDir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "segment")
+	if err := ioutil.WriteFile(path, buf.Bytes(), 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer reopened.Close()
+
+	if reopened.Count() != original.Count() {
+		t.Fatalf("got %d documents, want %d", reopened.Count(), original.Count())
+	}
+
+	origIDs, err := original.DocNumbers([]string{"a", "b", "c"})
+	if err != nil {
+		t.Fatalf("DocNumbers on original: %v", err)
+	}
+	reopenedIDs, err := reopened.DocNumbers([]string{"a", "b", "c"})
+	if err != nil {
+		t.Fatalf("DocNumbers on reopened: %v", err)
+	}
+	if origIDs.GetCardinality() != reopenedIDs.GetCardinality() {
+		t.Fatalf("got %d matching docs, want %d", reopenedIDs.GetCardinality(), origIDs.GetCardinality())
+	}
+
+	dict, err := reopened.Dictionary("name")
+	if err != nil {
+		t.Fatalf("Dictionary: %v", err)
+	}
+	postings, err := dict.PostingsList("dog", nil)
+	if err != nil {
+		t.Fatalf("PostingsList: %v", err)
+	}
+	if postings.Count() != 2 {
+		t.Fatalf("got %d postings for \"dog\", want 2", postings.Count())
+	}
+
+	var stored [][]byte
+	err = reopened.VisitDocument(0, func(field string, typ byte, value []byte, pos []uint64) bool {
+		stored = append(stored, append([]byte{}, value...))
+		return true
+	})
+	if err != nil {
+		t.Fatalf("VisitDocument: %v", err)
+	}
+	if len(stored) != 1 || string(stored[0]) != "dog" {
+		t.Fatalf("got stored values %v, want [\"dog\"]", stored)
+	}
+}
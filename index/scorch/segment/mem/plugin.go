@@ -0,0 +1,66 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mem
+
+import (
+	"fmt"
+
+	"github.com/RoaringBitmap/roaring"
+	"github.com/blevesearch/bleve/index"
+	"github.com/blevesearch/bleve/index/scorch/segment"
+)
+
+// Type is the name mem registers itself under with the segment.Plugin
+// registry.
+const Type = "mem"
+
+// Version is the on-disk layout version this build of the mem plugin
+// produces and understands.
+const Version = uint32(1)
+
+// Plugin adapts the mem package's Segment to segment.SegmentPlugin so
+// scorch can build and open mem segments through the registry instead of
+// importing this package directly.
+type Plugin struct{}
+
+func (*Plugin) Type() string {
+	return Type
+}
+
+func (*Plugin) Version() uint32 {
+	return Version
+}
+
+// New builds a mem segment from a batch of analyzed documents. It is a
+// thin wrapper over NewFromAnalyzedDocs to satisfy segment.SegmentPlugin.
+func (*Plugin) New(results []*index.AnalysisResult) (segment.Segment, error) {
+	return NewFromAnalyzedDocs(results), nil
+}
+
+// Open reads a previously persisted mem segment back from path.
+func (*Plugin) Open(path string) (segment.Segment, error) {
+	return Open(path)
+}
+
+// Merge is not supported for mem segments, including ones written with
+// WriteTo and reopened with Open: it always returns an error.
+func (*Plugin) Merge(segments []segment.Segment, drops []*roaring.Bitmap, path string,
+	closeCh chan struct{}) ([][]uint64, uint64, error) {
+	return nil, 0, fmt.Errorf("merging mem segments is not supported")
+}
+
+func init() {
+	segment.RegisterPlugin(&Plugin{})
+}
@@ -0,0 +1,51 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mem
+
+import (
+	"testing"
+
+	"github.com/blevesearch/bleve/index"
+	"github.com/blevesearch/bleve/index/scorch/segment"
+)
+
+func TestPluginRegistersItself(t *testing.T) {
+	plugin, err := segment.GetPlugin(Type, Version)
+	if err != nil {
+		t.Fatalf("mem did not register itself: %v", err)
+	}
+	if plugin.Type() != Type {
+		t.Fatalf("got type %q, want %q", plugin.Type(), Type)
+	}
+	if plugin.Version() != Version {
+		t.Fatalf("got version %d, want %d", plugin.Version(), Version)
+	}
+}
+
+func TestPluginNewBuildsASegment(t *testing.T) {
+	plugin, err := segment.GetPlugin(Type, Version)
+	if err != nil {
+		t.Fatalf("mem did not register itself: %v", err)
+	}
+
+	results := []*index.AnalysisResult{buildAnalysisResult("a", "dog")}
+	seg, err := plugin.New(results)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if seg.Count() != 1 {
+		t.Fatalf("got %d documents, want 1", seg.Count())
+	}
+}
@@ -0,0 +1,158 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mem
+
+import (
+	"github.com/RoaringBitmap/roaring"
+	"github.com/blevesearch/bleve/index/scorch/segment"
+)
+
+// PostingsList is the set of docNums (and per-doc freq/norm/location data)
+// for a single term, backed directly by the segment's parallel
+// Postings/Freqs/Norms/Loc* slices for pid. A zero-value PostingsList
+// (pid == 0, segment == nil) represents an empty postings list for a
+// term that does not occur in the field.
+type PostingsList struct {
+	segment *Segment
+	pid     uint64
+	except  *roaring.Bitmap
+}
+
+// Count returns the number of documents in this postings list, after
+// removing any docNums in except or marked deleted on the segment.
+func (p *PostingsList) Count() uint64 {
+	if p.segment == nil {
+		return 0
+	}
+	bm := p.segment.Postings[p.pid]
+	if p.except != nil {
+		bm = roaring.AndNot(bm, p.except)
+	}
+	if p.segment.Deleted != nil {
+		bm = roaring.AndNot(bm, p.segment.Deleted)
+	}
+	return bm.GetCardinality()
+}
+
+// Iterator walks the docNums in this postings list, in ascending order,
+// skipping any docNum in except or marked deleted on the segment.
+func (p *PostingsList) Iterator() segment.PostingsIterator {
+	if p.segment == nil {
+		return &PostingsIterator{}
+	}
+	return &PostingsIterator{
+		segment: p.segment,
+		pid:     p.pid,
+		docNums: p.segment.Postings[p.pid].Iterator(),
+		locs:    p.segment.PostingsLocs[p.pid],
+		except:  p.except,
+	}
+}
+
+// PostingsIterator walks a PostingsList's docNums in ascending order,
+// tracking the corresponding offsets into Freqs/Norms/Loc* as it goes.
+// It always walks the full, unfiltered Postings bitmap internally -
+// Freqs/Norms/Loc* are only aligned against that bitmap's complete
+// iteration order - and simply declines to return a Posting for any
+// docNum that should be excluded, so that freqIdx/locIdx stay in sync
+// regardless of how many documents end up skipped.
+type PostingsIterator struct {
+	segment *Segment
+	pid     uint64
+	docNums roaring.IntIterable
+	locs    *roaring.Bitmap
+	except  *roaring.Bitmap
+	freqIdx int
+	locIdx  int
+}
+
+// Next returns the next non-excluded document in the postings list, or
+// (nil, nil) when exhausted.
+func (i *PostingsIterator) Next() (segment.Posting, error) {
+	for i.docNums != nil && i.docNums.HasNext() {
+		docNum := uint64(i.docNums.Next())
+
+		freqIdx := i.freqIdx
+		i.freqIdx++
+
+		freq := i.segment.Freqs[i.pid][freqIdx]
+		norm := i.segment.Norms[i.pid][freqIdx]
+
+		locStart := i.locIdx
+		locCount := 0
+		if i.locs != nil && i.locs.Contains(uint32(docNum)) {
+			// every field+term occurrence of docNum contributed one entry
+			// to the pid's Loc* arrays, in the same order docNums were
+			// added, so this is simply a run of length freq
+			locCount = int(freq)
+		}
+		i.locIdx += locCount
+
+		if (i.except != nil && i.except.Contains(uint32(docNum))) ||
+			(i.segment.Deleted != nil && i.segment.Deleted.Contains(uint32(docNum))) {
+			continue
+		}
+
+		p := &Posting{
+			docNum: docNum,
+			freq:   freq,
+			norm:   float64(norm),
+		}
+		if locCount > 0 {
+			p.locations = make([]segment.Location, 0, locCount)
+			for j := 0; j < locCount; j++ {
+				idx := locStart + j
+				p.locations = append(p.locations, &Location{
+					field:    i.segment.FieldsInv[i.segment.Locfields[i.pid][idx]],
+					start:    i.segment.Locstarts[i.pid][idx],
+					end:      i.segment.Locends[i.pid][idx],
+					pos:      i.segment.Locpos[i.pid][idx],
+					arrayPos: i.segment.Locarraypos[i.pid][idx],
+				})
+			}
+		}
+
+		return p, nil
+	}
+	return nil, nil
+}
+
+// Posting is one document's contribution to a PostingsList.
+type Posting struct {
+	docNum    uint64
+	freq      uint64
+	norm      float64
+	locations []segment.Location
+}
+
+func (p *Posting) Number() uint64                { return p.docNum }
+func (p *Posting) Frequency() uint64             { return p.freq }
+func (p *Posting) Norm() float64                 { return p.norm }
+func (p *Posting) Locations() []segment.Location { return p.locations }
+
+// Location is a single occurrence of a term within a document field.
+type Location struct {
+	field    string
+	start    uint64
+	end      uint64
+	pos      uint64
+	arrayPos []uint64
+}
+
+func (l *Location) Field() string            { return l.field }
+func (l *Location) Start() uint64            { return l.start }
+func (l *Location) End() uint64              { return l.end }
+func (l *Location) Pos() uint64              { return l.pos }
+func (l *Location) ArrayPositions() []uint64 { return l.arrayPos }
@@ -0,0 +1,154 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mem
+
+import (
+	"sync"
+
+	"github.com/RoaringBitmap/roaring"
+	mmap "github.com/blevesearch/mmap-go"
+)
+
+// Segment holds the complete set of mutations contributed by one batch of
+// analyzed documents: the term dictionary, postings, norms, locations
+// and stored field values. It is built either in one shot, from
+// NewFromAnalyzedDocs, or incrementally via NewBuilder/AddDocument/Build,
+// and can optionally be backed by an mmap'd file opened with Open.
+type Segment struct {
+	FieldsMap map[string]uint16 // fieldName -> fieldID+1
+	FieldsInv []string          // fieldID -> fieldName
+
+	Dicts    []map[string]uint64 // fieldID -> term -> postingsID+1
+	DictKeys [][]string          // fieldID -> terms, sorted after Build/NewFromAnalyzedDocs
+
+	Postings     []*roaring.Bitmap // postingsID -> docNums containing the term
+	PostingsLocs []*roaring.Bitmap // postingsID -> docNums that also have location info
+
+	Freqs [][]uint64
+	Norms [][]float32
+
+	Locfields   [][]uint16
+	Locstarts   [][]uint64
+	Locends     [][]uint64
+	Locpos      [][]uint64
+	Locarraypos [][][]uint64
+
+	Stored      []map[uint16][][]byte
+	StoredTypes []map[uint16][]byte
+	StoredPos   []map[uint16][][]uint64
+
+	DocValueFields map[uint16]bool
+
+	// Deleted holds the docNums marked deleted via DeleteDocument. It is
+	// nil until the first deletion and is consulted by every posting
+	// iterator, so a deleted document stops contributing hits without
+	// its data actually being removed from Postings/Stored/etc.
+	Deleted *roaring.Bitmap
+
+	// mmap backs a Segment returned by Open; it is nil for segments built
+	// in memory. Close unmaps it.
+	mmap mmap.MMap
+
+	// dictRegions, dictNumTerms and dictOnce exist only on segments
+	// returned by Open. Dicts[fieldID]/DictKeys[fieldID] for such a
+	// segment start out nil; ensureFieldDict decodes them from
+	// dictRegions[fieldID] (a slice directly into the mmap'd file, no
+	// copy) the first time that field's dictionary is actually used,
+	// guarded by dictOnce[fieldID] so concurrent readers only decode it
+	// once. Segments built in memory never populate these and always
+	// have Dicts/DictKeys ready to use.
+	dictRegions  [][]byte
+	dictNumTerms []uint64
+	dictOnce     []sync.Once
+
+	sizeInBytes uint64
+}
+
+// New creates an empty Segment ready to be populated by
+// NewFromAnalyzedDocs or a SegmentBuilder.
+func New() *Segment {
+	return &Segment{
+		FieldsMap:      map[string]uint16{},
+		DocValueFields: map[uint16]bool{},
+	}
+}
+
+// SizeInBytes returns the segment's approximate in-memory footprint, as
+// last computed by updateSizeInBytes.
+func (s *Segment) SizeInBytes() uint64 {
+	return s.sizeInBytes
+}
+
+// updateSizeInBytes recomputes the segment's approximate in-memory
+// footprint. It is called by NewFromAnalyzedDocs, Build, DeleteDocument,
+// RollbackTo and Open. AddDocument does not call it, so documents added
+// since the last Build are not counted until Build runs again; for a
+// segment returned by Open, field dictionaries not yet decoded by
+// ensureFieldDict are not counted either.
+func (s *Segment) updateSizeInBytes() {
+	var sizeInBytes uint64
+
+	for k := range s.FieldsMap {
+		sizeInBytes += uint64(len(k)) + 2 /* uint16 */
+	}
+	for _, name := range s.FieldsInv {
+		sizeInBytes += uint64(len(name))
+	}
+
+	for fieldID, dict := range s.Dicts {
+		for term := range dict {
+			sizeInBytes += uint64(len(term)) + 8 /* uint64 */
+		}
+		for _, term := range s.DictKeys[fieldID] {
+			sizeInBytes += uint64(len(term))
+		}
+	}
+
+	if s.Deleted != nil {
+		sizeInBytes += s.Deleted.GetSizeInBytes()
+	}
+
+	for pid := range s.Postings {
+		if s.Postings[pid] != nil {
+			sizeInBytes += s.Postings[pid].GetSizeInBytes()
+		}
+		if s.PostingsLocs[pid] != nil {
+			sizeInBytes += s.PostingsLocs[pid].GetSizeInBytes()
+		}
+		sizeInBytes += uint64(len(s.Freqs[pid])) * 8
+		sizeInBytes += uint64(len(s.Norms[pid])) * 4
+		sizeInBytes += uint64(len(s.Locfields[pid])) * 2
+		sizeInBytes += uint64(len(s.Locstarts[pid])) * 8
+		sizeInBytes += uint64(len(s.Locends[pid])) * 8
+		sizeInBytes += uint64(len(s.Locpos[pid])) * 8
+		for _, ap := range s.Locarraypos[pid] {
+			sizeInBytes += uint64(len(ap)) * 8
+		}
+	}
+
+	for docNum := range s.Stored {
+		for fieldID, values := range s.Stored[docNum] {
+			for _, v := range values {
+				sizeInBytes += uint64(len(v))
+			}
+			sizeInBytes += uint64(len(s.StoredTypes[docNum][fieldID]))
+			for _, pos := range s.StoredPos[docNum][fieldID] {
+				sizeInBytes += uint64(len(pos)) * 8
+			}
+		}
+	}
+
+	s.sizeInBytes = sizeInBytes
+}
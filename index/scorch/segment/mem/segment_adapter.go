@@ -0,0 +1,90 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mem
+
+import (
+	"github.com/RoaringBitmap/roaring"
+
+	"github.com/blevesearch/bleve/index/scorch/segment"
+)
+
+// the remaining methods required to satisfy segment.Segment; Dictionary
+// lives in dict.go alongside the rest of the dictionary machinery.
+
+// VisitDocument invokes visitor once for every stored field value
+// belonging to docNum. A field's values are visited in position order;
+// the order in which fields are visited is not defined.
+func (s *Segment) VisitDocument(docNum uint64, visitor segment.DocumentFieldValueVisitor) error {
+	for fieldID, values := range s.Stored[docNum] {
+		field := s.FieldsInv[fieldID]
+		types := s.StoredTypes[docNum][fieldID]
+		positions := s.StoredPos[docNum][fieldID]
+		for i, value := range values {
+			keepGoing := visitor(field, types[i], value, positions[i])
+			if !keepGoing {
+				return nil
+			}
+		}
+	}
+	return nil
+}
+
+// Count returns the number of documents, live or deleted, in the
+// segment.
+func (s *Segment) Count() uint64 {
+	return uint64(len(s.Stored))
+}
+
+// DocNumbers returns the bitmap of docNums whose "_id" field is one of
+// ids. "_id" is always fieldID 0 (see NewFromAnalyzedDocs/NewBuilder).
+func (s *Segment) DocNumbers(ids []string) (*roaring.Bitmap, error) {
+	rv := roaring.New()
+	idDict, err := s.Dictionary("_id")
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		postings, err := idDict.PostingsList(id, nil)
+		if err != nil {
+			return nil, err
+		}
+		it := postings.Iterator()
+		next, err := it.Next()
+		for next != nil && err == nil {
+			rv.AddInt(int(next.Number()))
+			next, err = it.Next()
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return rv, nil
+}
+
+// Fields returns the names of every field seen by this segment, in the
+// order fieldIDs were assigned.
+func (s *Segment) Fields() []string {
+	return s.FieldsInv
+}
+
+// Close releases the mmap'd file backing a segment opened with Open, if
+// any. Segments built in memory via NewFromAnalyzedDocs/NewBuilder hold
+// no external resources, so Close is a no-op for them.
+func (s *Segment) Close() error {
+	if s.mmap != nil {
+		return s.mmap.Unmap()
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package segment
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/RoaringBitmap/roaring"
+	"github.com/blevesearch/bleve/index"
+)
+
+// SegmentPlugin is implemented by every on-disk or in-memory segment
+// format (mem, zap, ...) that wants to be usable by a scorch index. A
+// plugin is looked up by Type/Version out of the registry, so the
+// indexer never needs to import a concrete segment package directly.
+type SegmentPlugin interface {
+	// Type identifies the segment format, e.g. "mem" or "zap".
+	Type() string
+
+	// Version identifies the on-disk layout of this format. Segments
+	// read back from disk carry their own version so older files keep
+	// working after a format's in-memory representation changes.
+	Version() uint32
+
+	// New builds a segment from a batch of analyzed documents.
+	New(results []*index.AnalysisResult) (Segment, error)
+
+	// Open reads a previously persisted segment back from path.
+	Open(path string) (Segment, error)
+
+	// Merge combines segments into a single new segment at path,
+	// dropping any docNum set in the corresponding entry of drops. It
+	// returns, for each input segment, the new docNum that each of its
+	// live docNums was remapped to, followed by the total count of
+	// documents in the merged segment.
+	Merge(segments []Segment, drops []*roaring.Bitmap, path string,
+		closeCh chan struct{}) ([][]uint64, uint64, error)
+}
+
+// IndexConfig selects which registered SegmentPlugin a scorch index
+// should use to build and open its segments.
+type IndexConfig struct {
+	SegmentType    string
+	SegmentVersion uint32
+}
+
+var pluginMutex sync.RWMutex
+var plugins = map[string]map[uint32]SegmentPlugin{}
+
+// RegisterPlugin makes a SegmentPlugin available to NewSegment/OpenSegment
+// under its own Type()/Version(). Plugins register themselves from an
+// init() function in their package.
+func RegisterPlugin(plugin SegmentPlugin) {
+	pluginMutex.Lock()
+	defer pluginMutex.Unlock()
+
+	versions, ok := plugins[plugin.Type()]
+	if !ok {
+		versions = map[uint32]SegmentPlugin{}
+		plugins[plugin.Type()] = versions
+	}
+	versions[plugin.Version()] = plugin
+}
+
+// GetPlugin looks up a previously registered SegmentPlugin by type and
+// version.
+func GetPlugin(segmentType string, segmentVersion uint32) (SegmentPlugin, error) {
+	pluginMutex.RLock()
+	defer pluginMutex.RUnlock()
+
+	versions, ok := plugins[segmentType]
+	if !ok {
+		return nil, fmt.Errorf("no plugin registered for segment type: %s", segmentType)
+	}
+	plugin, ok := versions[segmentVersion]
+	if !ok {
+		return nil, fmt.Errorf("no plugin registered for segment type: %s version: %d", segmentType, segmentVersion)
+	}
+	return plugin, nil
+}
+
+// NewSegment builds a new segment from results using the plugin named by
+// cfg, so callers never need to import a concrete segment package.
+func NewSegment(cfg IndexConfig, results []*index.AnalysisResult) (Segment, error) {
+	plugin, err := GetPlugin(cfg.SegmentType, cfg.SegmentVersion)
+	if err != nil {
+		return nil, err
+	}
+	return plugin.New(results)
+}
+
+// OpenSegment opens a previously persisted segment at path using the
+// plugin named by cfg.
+func OpenSegment(cfg IndexConfig, path string) (Segment, error) {
+	plugin, err := GetPlugin(cfg.SegmentType, cfg.SegmentVersion)
+	if err != nil {
+		return nil, err
+	}
+	return plugin.Open(path)
+}
@@ -0,0 +1,78 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package segment
+
+import (
+	"testing"
+
+	"github.com/RoaringBitmap/roaring"
+	"github.com/blevesearch/bleve/index"
+)
+
+type fakePlugin struct {
+	typ     string
+	version uint32
+}
+
+func (p *fakePlugin) Type() string    { return p.typ }
+func (p *fakePlugin) Version() uint32 { return p.version }
+
+func (p *fakePlugin) New(results []*index.AnalysisResult) (Segment, error) {
+	return nil, nil
+}
+
+func (p *fakePlugin) Open(path string) (Segment, error) {
+	return nil, nil
+}
+
+func (p *fakePlugin) Merge(segments []Segment, drops []*roaring.Bitmap, path string,
+	closeCh chan struct{}) ([][]uint64, uint64, error) {
+	return nil, 0, nil
+}
+
+func TestRegisterAndGetPlugin(t *testing.T) {
+	plugin := &fakePlugin{typ: "fake-for-test", version: 7}
+	RegisterPlugin(plugin)
+
+	got, err := GetPlugin("fake-for-test", 7)
+	if err != nil {
+		t.Fatalf("GetPlugin: %v", err)
+	}
+	if got != SegmentPlugin(plugin) {
+		t.Fatalf("GetPlugin returned a different plugin than was registered")
+	}
+
+	if _, err := GetPlugin("fake-for-test", 8); err == nil {
+		t.Fatal("expected an error looking up an unregistered version")
+	}
+	if _, err := GetPlugin("does-not-exist", 7); err == nil {
+		t.Fatal("expected an error looking up an unregistered type")
+	}
+}
+
+func TestNewSegmentUsesConfiguredPlugin(t *testing.T) {
+	plugin := &fakePlugin{typ: "fake-for-new", version: 1}
+	RegisterPlugin(plugin)
+
+	cfg := IndexConfig{SegmentType: "fake-for-new", SegmentVersion: 1}
+	if _, err := NewSegment(cfg, nil); err != nil {
+		t.Fatalf("NewSegment: %v", err)
+	}
+
+	cfg.SegmentType = "unregistered"
+	if _, err := NewSegment(cfg, nil); err == nil {
+		t.Fatal("expected an error building a segment with an unregistered type")
+	}
+}
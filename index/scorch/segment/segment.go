@@ -0,0 +1,105 @@
+//  Copyright (c) 2017 Couchbase, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// 		http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package segment defines the abstract on-disk/in-memory segment formats
+// that scorch indexes are built from, so that the indexer can operate
+// against any format registered as a SegmentPlugin instead of hard-coding
+// a single implementation.
+package segment
+
+import (
+	"github.com/RoaringBitmap/roaring"
+)
+
+// Segment represents the set of mutations (terms, postings, locations and
+// stored fields) contributed by one batch of documents, regardless of
+// whether it is backed by memory (mem) or a file on disk (zap and
+// friends).
+type Segment interface {
+	// Dictionary returns the term dictionary for the named field.
+	Dictionary(field string) (TermDictionary, error)
+
+	// VisitDocument invokes visitor once for every stored field value
+	// belonging to docNum.
+	VisitDocument(docNum uint64, visitor DocumentFieldValueVisitor) error
+
+	// Count returns the number of documents (live or deleted) in the
+	// segment.
+	Count() uint64
+
+	// DocNumbers returns the bitmap of docNums whose "_id" field value is
+	// one of ids.
+	DocNumbers(ids []string) (*roaring.Bitmap, error)
+
+	// Fields returns the names of every field known to this segment,
+	// including fields that have no indexed term in it.
+	Fields() []string
+
+	// Close releases any resources (e.g. mmap'd file handles) held by the
+	// segment.
+	Close() error
+}
+
+// DocumentFieldValueVisitor is invoked once per stored value of a
+// document field. Returning false stops the visit early.
+type DocumentFieldValueVisitor func(field string, typ byte, value []byte, pos []uint64) bool
+
+// TermDictionary maps terms in a single field to their postings lists.
+type TermDictionary interface {
+	PostingsList(term string, except *roaring.Bitmap) (PostingsList, error)
+
+	Iterator() DictionaryIterator
+	PrefixIterator(prefix string) DictionaryIterator
+	RangeIterator(start, end string) DictionaryIterator
+}
+
+// DictionaryIterator walks a TermDictionary in term order.
+type DictionaryIterator interface {
+	Next() (*DictEntry, error)
+}
+
+// DictEntry is a single term and the number of documents it occurs in.
+type DictEntry struct {
+	Term  string
+	Count uint64
+}
+
+// PostingsList is the set of documents (and, for each, frequency/norm/
+// location data) containing a single term.
+type PostingsList interface {
+	Iterator() PostingsIterator
+	Count() uint64
+}
+
+// PostingsIterator walks a PostingsList in docNum order.
+type PostingsIterator interface {
+	Next() (Posting, error)
+}
+
+// Posting is one document's contribution to a PostingsList.
+type Posting interface {
+	Number() uint64
+	Frequency() uint64
+	Norm() float64
+	Locations() []Location
+}
+
+// Location is a single occurrence of a term within a document field.
+type Location interface {
+	Field() string
+	Start() uint64
+	End() uint64
+	Pos() uint64
+	ArrayPositions() []uint64
+}